@@ -0,0 +1,955 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main implements a Cloud Build notifier that files GitHub issues
+// for build events.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	cbpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"github.com/google/go-github/v63/github"
+)
+
+const (
+	defaultAPIBaseURL = "https://api.github.com"
+
+	// defaultIssueTemplate is used when the delivery config does not supply
+	// its own `message` template.
+	defaultIssueTemplate = `
+{
+    "title": "Cloud Build [{{.Build.ProjectId}}]: {{.Build.Status}}",
+    "body": "Cloud Build {{.Build.ProjectId}} {{.Build.BuildTriggerId}} status: **{{.Build.Status}}**\n\n[View Logs]({{.Build.LogUrl}})"
+}`
+
+	// appJWTTTL is how long a GitHub App JWT is valid for. GitHub rejects
+	// anything over 10 minutes.
+	appJWTTTL = 9 * time.Minute
+	// tokenRefreshSkew is how far ahead of the installation token's
+	// expires_at we refresh it.
+	tokenRefreshSkew = 1 * time.Minute
+
+	// maxRetries bounds how many times retryTransport will retry a single
+	// request for rate limiting or transient server errors.
+	maxRetries = 5
+	// baseRetryBackoff is the starting wait for the exponential backoff
+	// applied to transient 5xx responses.
+	baseRetryBackoff = 1 * time.Second
+)
+
+func main() {
+	if err := notifiers.Main(context.Background(), new(githubissuesNotifier)); err != nil {
+		log.Fatalf("fatal error: %v", err)
+	}
+}
+
+type githubissuesNotifier struct {
+	filter   notifiers.EventFilter
+	tmpl     *template.Template
+	tmplView *notifiers.TemplateView
+
+	githubRepo string
+	apiBaseURL string
+	br         notifiers.BindingResolver
+
+	// httpClient is used for every call to the GitHub REST API. SetUp only
+	// sets it if it's still nil, so tests can inject their own (e.g. an
+	// httptest-backed client) by populating it before calling SetUp, or
+	// bypass SetUp entirely and call the free functions directly.
+	httpClient *http.Client
+
+	// ghc and the fields alongside it cache the go-github client built by
+	// ghClient, which is otherwise expensive to reconstruct (URL parse,
+	// transport wrapping) on every API call. The cache is invalidated
+	// whenever the base URL or auth token it was built with no longer
+	// matches what ghClient is asked for next, which naturally picks up a
+	// refreshed GitHub App installation token.
+	ghc        *github.Client
+	ghcBaseURL string
+	ghcToken   string
+
+	// dedupe enables fingerprint-based issue reuse: instead of always
+	// opening a new issue, failing builds are matched against existing
+	// open issues and successful builds close them out.
+	dedupe bool
+
+	// githubToken is set when the delivery config authenticates with a
+	// static personal access token.
+	githubToken string
+
+	// The following fields are set when the delivery config authenticates
+	// as a GitHub App installation instead of a static token.
+	appID             int64
+	installationID    int64
+	appPrivateKey     *rsa.PrivateKey
+	installationToken string
+	installationExp   time.Time
+
+	// labelTemplates and assigneeTemplates are evaluated against the
+	// issueView for every issue created, and their results merged into the
+	// label/assignee lists. milestoneTemplate, if set, resolves to a
+	// milestone title that's looked up for its number at issue-creation
+	// time.
+	labelTemplates    []*template.Template
+	assigneeTemplates []*template.Template
+	milestoneTemplate *template.Template
+}
+
+// issueView extends notifiers.TemplateView with the committer login
+// resolved by GetAndSetCommitterInfo, so that the issue body template and
+// the labels/assignees/milestone templates can all reference
+// {{.CommitterLogin}} in addition to the usual {{.Build...}} fields.
+type issueView struct {
+	*notifiers.TemplateView
+	CommitterLogin string
+}
+
+func (g *githubissuesNotifier) SetUp(ctx context.Context, cfg *notifiers.Config, notifierID string, sg notifiers.SecretGetter, br notifiers.BindingResolver) error {
+	g.br = br
+
+	filter, err := notifiers.MakeCELPredicate(cfg.Spec.Notification.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to make a CEL predicate: %w", err)
+	}
+	g.filter = filter
+
+	tmpl, err := template.New("issue_template").Parse(defaultIssueTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse the default issue template: %w", err)
+	}
+	g.tmpl = tmpl
+
+	delivery := cfg.Spec.Notification.Delivery
+	repo, ok := delivery["githubRepo"].(string)
+	if !ok || repo == "" {
+		return fmt.Errorf("expected delivery config %v to have string field githubRepo", delivery)
+	}
+	g.githubRepo = repo
+
+	apiBaseURL, err := validateAPIBaseURL(delivery["githubApiUrl"])
+	if err != nil {
+		return fmt.Errorf("invalid githubApiUrl: %w", err)
+	}
+	g.apiBaseURL = apiBaseURL
+
+	if g.httpClient == nil {
+		g.httpClient = &http.Client{Transport: newRetryTransport(http.DefaultTransport)}
+	}
+
+	if dedupe, ok := delivery["dedupe"]; ok {
+		b, ok := dedupe.(bool)
+		if !ok {
+			return fmt.Errorf("expected delivery config field dedupe to be a bool, got %v", dedupe)
+		}
+		g.dedupe = b
+	}
+
+	if err := g.setUpAuth(ctx, cfg, sg); err != nil {
+		return err
+	}
+
+	if raw, ok := delivery["labels"]; ok {
+		tmpls, err := parseTemplateList(raw, "labels")
+		if err != nil {
+			return err
+		}
+		g.labelTemplates = tmpls
+	}
+	if raw, ok := delivery["assignees"]; ok {
+		tmpls, err := parseTemplateList(raw, "assignees")
+		if err != nil {
+			return err
+		}
+		g.assigneeTemplates = tmpls
+	}
+	if raw, ok := delivery["milestone"]; ok {
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected delivery config field milestone to be a string, got %v", raw)
+		}
+		tmpl, err := template.New("milestone").Parse(s)
+		if err != nil {
+			return fmt.Errorf("failed to parse milestone template %q: %w", s, err)
+		}
+		g.milestoneTemplate = tmpl
+	}
+	if err := g.validateTemplates(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseTemplateList parses a delivery config field that's expected to be a
+// list of Go template strings (e.g. `labels`, `assignees`).
+func parseTemplateList(raw interface{}, field string) ([]*template.Template, error) {
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config field %s to be a list, got %v", field, raw)
+	}
+	out := make([]*template.Template, 0, len(list))
+	for i, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field %s to contain strings, got %v", field, v)
+		}
+		tmpl, err := template.New(fmt.Sprintf("%s[%d]", field, i)).Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s template %q: %w", field, s, err)
+		}
+		out = append(out, tmpl)
+	}
+	return out, nil
+}
+
+// validateTemplates catches unknown template variables at SetUp time by
+// executing every configured labels/assignees/milestone template against a
+// zero-value issueView, rather than waiting for SendNotification to fail on
+// the first build.
+func (g *githubissuesNotifier) validateTemplates() error {
+	view := &issueView{TemplateView: &notifiers.TemplateView{Build: &notifiers.BuildView{Build: &cbpb.Build{}}}}
+	for _, tmpl := range g.labelTemplates {
+		if err := tmpl.Execute(io.Discard, view); err != nil {
+			return fmt.Errorf("invalid labels template %q: %w", tmpl.Name(), err)
+		}
+	}
+	for _, tmpl := range g.assigneeTemplates {
+		if err := tmpl.Execute(io.Discard, view); err != nil {
+			return fmt.Errorf("invalid assignees template %q: %w", tmpl.Name(), err)
+		}
+	}
+	if g.milestoneTemplate != nil {
+		if err := g.milestoneTemplate.Execute(io.Discard, view); err != nil {
+			return fmt.Errorf("invalid milestone template: %w", err)
+		}
+	}
+	return nil
+}
+
+// setUpAuth configures exactly one of the two supported GitHub
+// authentication modes: a static `githubToken` secret ref, or a
+// `githubApp` block naming an App ID, an installation ID, and a secret ref
+// to the App's PEM private key.
+func (g *githubissuesNotifier) setUpAuth(ctx context.Context, cfg *notifiers.Config, sg notifiers.SecretGetter) error {
+	delivery := cfg.Spec.Notification.Delivery
+
+	if ref, ok := asStringMap(delivery["githubToken"]); ok {
+		token, err := resolveSecretRef(ctx, ref, cfg.Spec.Secrets, sg)
+		if err != nil {
+			return fmt.Errorf("failed to resolve githubToken secretRef: %w", err)
+		}
+		g.githubToken = token
+		return nil
+	}
+
+	app, ok := asStringMap(delivery["githubApp"])
+	if !ok {
+		return fmt.Errorf("delivery config must set either githubToken or githubApp")
+	}
+
+	appIDRaw, ok := app["appId"]
+	if !ok {
+		return fmt.Errorf("githubApp config must set appId")
+	}
+	appID, err := parseConfigInt64(appIDRaw, "appId")
+	if err != nil {
+		return err
+	}
+	installationIDRaw, ok := app["installationId"]
+	if !ok {
+		return fmt.Errorf("githubApp config must set installationId")
+	}
+	installationID, err := parseConfigInt64(installationIDRaw, "installationId")
+	if err != nil {
+		return err
+	}
+	pemStr, err := resolveSecretRef(ctx, app, cfg.Spec.Secrets, sg)
+	if err != nil {
+		return fmt.Errorf("failed to resolve githubApp private key secretRef: %w", err)
+	}
+	key, err := parseRSAPrivateKey(pemStr)
+	if err != nil {
+		return fmt.Errorf("failed to parse githubApp private key: %w", err)
+	}
+
+	g.appID = appID
+	g.installationID = installationID
+	g.appPrivateKey = key
+	return nil
+}
+
+// validateAPIBaseURL validates an optional `githubApiUrl` delivery config
+// value, returning defaultAPIBaseURL (api.github.com) when unset. This lets
+// the notifier target a GitHub Enterprise Server instance instead.
+func validateAPIBaseURL(v interface{}) (string, error) {
+	if v == nil {
+		return defaultAPIBaseURL, nil
+	}
+	raw, ok := v.(string)
+	if !ok || raw == "" {
+		return "", fmt.Errorf("expected githubApiUrl to be a string, got %v", v)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %q as a URL: %w", raw, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("githubApiUrl %q must be an absolute https URL", raw)
+	}
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// asStringMap normalizes either a map[string]interface{} or a
+// map[interface{}]interface{} (as produced by YAML unmarshaling) into a
+// map[string]interface{}.
+func asStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			out[ks] = val
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// parseConfigInt64 coerces a delivery config value for field into an
+// int64, accepting the int, int64, and float64 representations a YAML/JSON
+// unmarshaler might produce as well as a numeric string, and rejecting
+// anything else instead of silently defaulting to 0.
+func parseConfigInt64(v interface{}, field string) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case float64:
+		if n != float64(int64(n)) {
+			return 0, fmt.Errorf("expected delivery config field %s to be a whole number, got %v", field, v)
+		}
+		return int64(n), nil
+	case string:
+		out, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected delivery config field %s to be numeric, got %q", field, n)
+		}
+		return out, nil
+	default:
+		return 0, fmt.Errorf("expected delivery config field %s to be a number, got %v", field, v)
+	}
+}
+
+func resolveSecretRef(ctx context.Context, ref map[string]interface{}, secrets []*notifiers.Secret, sg notifiers.SecretGetter) (string, error) {
+	localName, ok := ref["secretRef"].(string)
+	if !ok {
+		return "", fmt.Errorf("expected %v to have string field secretRef", ref)
+	}
+	for _, s := range secrets {
+		if s.LocalName == localName {
+			return sg.GetSecret(ctx, s.ResourceName)
+		}
+	}
+	return "", fmt.Errorf("no secret found for secretRef %q", localName)
+}
+
+func parseRSAPrivateKey(pemStr string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS1 or PKCS8 private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// GetGithubRepo returns the `owner/repo` slug for the build, as set by the
+// REPO_FULL_NAME substitution.
+func GetGithubRepo(build *cbpb.Build) string {
+	return build.GetSubstitutions()["REPO_FULL_NAME"]
+}
+
+// GetAndSetCommitterInfo looks up the human who authored the triggering
+// commit (or tagged the triggering release) and stashes their login/name
+// in the GH_COMMITTER_LOGIN substitution so that templates can reference
+// it.
+func GetAndSetCommitterInfo(ctx context.Context, build *cbpb.Build, g *githubissuesNotifier, apiBaseURL string) error {
+	repo := GetGithubRepo(build)
+	if repo == "" {
+		return nil
+	}
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return err
+	}
+
+	client, err := g.ghClient(ctx, apiBaseURL)
+	if err != nil {
+		return err
+	}
+
+	if build.GetSubstitutions()["REF_NAME"] == "tag" {
+		rel, _, err := client.Repositories.GetReleaseByTag(ctx, owner, name, build.GetSubstitutions()["TAG_NAME"])
+		if err != nil {
+			return fmt.Errorf("failed to get release: %w", err)
+		}
+		build.Substitutions["GH_COMMITTER_LOGIN"] = rel.GetAuthor().GetLogin()
+		return nil
+	}
+
+	commit, _, err := client.Repositories.GetCommit(ctx, owner, name, build.GetSubstitutions()["BRANCH_NAME"], nil)
+	if err != nil {
+		return fmt.Errorf("failed to get commit: %w", err)
+	}
+	build.Substitutions["GH_COMMITTER_LOGIN"] = commit.GetCommit().GetAuthor().GetName()
+	return nil
+}
+
+// splitRepo splits a `owner/repo` slug into its two parts.
+func splitRepo(repo string) (owner, name string, err error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("expected githubRepo %q to be of the form owner/repo", repo)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ghClient returns a go-github client pointed at apiBaseURL (so that GitHub
+// Enterprise Server and test httptest servers both work), authenticated
+// with whatever credential getToken currently has available. The client is
+// cached on the notifier and only rebuilt when apiBaseURL or the token
+// change, rather than reconstructed on every call.
+func (g *githubissuesNotifier) ghClient(ctx context.Context, apiBaseURL string) (*github.Client, error) {
+	token, err := g.getToken(ctx)
+	if err != nil {
+		// SetUp guarantees one of githubToken/githubApp is configured, so
+		// the only legitimate way to land here is a caller that built a
+		// bare githubissuesNotifier without going through SetUp at all
+		// (as some tests do). Anything else is a real credential failure
+		// (e.g. minting an installation token failed) and must propagate,
+		// not silently fall back to an unauthenticated request.
+		if g.githubToken == "" && g.appPrivateKey == nil {
+			token = ""
+		} else {
+			return nil, fmt.Errorf("failed to get GitHub credential: %w", err)
+		}
+	}
+	if g.ghc != nil && g.ghcBaseURL == apiBaseURL && g.ghcToken == token {
+		return g.ghc, nil
+	}
+
+	client := github.NewClient(g.client())
+	if token != "" {
+		client = client.WithAuthToken(token)
+	}
+	u, err := url.Parse(apiBaseURL + "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse API base URL %q: %w", apiBaseURL, err)
+	}
+	client.BaseURL = u
+
+	g.ghc = client
+	g.ghcBaseURL = apiBaseURL
+	g.ghcToken = token
+	return client, nil
+}
+
+// client returns the notifier's HTTP client, falling back to
+// http.DefaultClient for call sites exercised without going through SetUp
+// (as the existing tests do).
+func (g *githubissuesNotifier) client() *http.Client {
+	if g.httpClient != nil {
+		return g.httpClient
+	}
+	return http.DefaultClient
+}
+
+// getToken returns a valid credential for calling the GitHub REST API,
+// minting and caching a fresh installation access token if the notifier is
+// configured for GitHub App auth.
+func (g *githubissuesNotifier) getToken(ctx context.Context) (string, error) {
+	if g.githubToken != "" {
+		return g.githubToken, nil
+	}
+	if g.appPrivateKey == nil {
+		return "", fmt.Errorf("no GitHub authentication configured")
+	}
+	if g.installationToken != "" && time.Now().Before(g.installationExp.Add(-tokenRefreshSkew)) {
+		return g.installationToken, nil
+	}
+
+	token, exp, err := g.mintInstallationToken(ctx, g.apiBaseURL)
+	if err != nil {
+		return "", err
+	}
+	g.installationToken = token
+	g.installationExp = exp
+	return token, nil
+}
+
+func (g *githubissuesNotifier) mintInstallationToken(ctx context.Context, apiBaseURL string) (string, time.Time, error) {
+	jwt, err := signAppJWT(g.appID, g.appPrivateKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign App JWT: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", apiBaseURL, g.installationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("failed to mint installation token: %s: %s", resp.Status, body)
+	}
+
+	var out struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to unmarshal access token response: %w", err)
+	}
+	return out.Token, out.ExpiresAt, nil
+}
+
+// signAppJWT builds and signs the short-lived JWT that GitHub Apps use to
+// authenticate as themselves (as opposed to as an installation).
+func signAppJWT(appID int64, key *rsa.PrivateKey) (string, error) {
+	now := time.Now()
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		// Backdate iat slightly to tolerate clock drift between us and
+		// GitHub, as recommended in GitHub's own docs.
+		"iat": now.Add(-30 * time.Second).Unix(),
+		"exp": now.Add(appJWTTTL).Unix(),
+		"iss": fmt.Sprintf("%d", appID),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// retryTransport wraps an http.RoundTripper so that go-github's requests
+// transparently retry on GitHub's primary and secondary rate limits and on
+// transient 5xx responses. Once retries are exhausted, the final response
+// is returned as-is, which go-github's CheckResponse turns into a
+// *github.RateLimitError or *github.AbuseRateLimitError for the caller when
+// GitHub flagged it as a primary or secondary rate limit (a 403 with
+// x-ratelimit-remaining: 0, or a documentation_url pointing at the
+// secondary rate-limit docs); other responses surface as a plain
+// *github.ErrorResponse.
+type retryTransport struct {
+	base http.RoundTripper
+	// sleep is called to wait out a retry backoff. It defaults to
+	// sleepWithJitter; tests override it to make exhausted-retry cases
+	// run without real wall-clock delay.
+	sleep func(time.Duration)
+}
+
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base, sleep: sleepWithJitter}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body for retry: %w", err)
+			}
+			req.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || attempt >= maxRetries {
+			return resp, err
+		}
+
+		if wait, ok := rateLimitRetryAfter(resp); ok {
+			resp.Body.Close()
+			t.sleep(wait)
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			t.sleep(baseRetryBackoff << uint(attempt))
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// rateLimitRetryAfter inspects a response for GitHub's primary
+// (x-ratelimit-remaining: 0) and secondary (Retry-After) rate-limit
+// signals, returning how long to wait before retrying.
+func rateLimitRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if resp.Header.Get("x-ratelimit-remaining") == "0" {
+		if reset := resp.Header.Get("x-ratelimit-reset"); reset != "" {
+			if ts, err := strconv.ParseInt(reset, 10, 64); err == nil {
+				if wait := time.Until(time.Unix(ts, 0)); wait > 0 {
+					return wait, true
+				}
+				return 0, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// sleepWithJitter waits out a retry backoff, adding up to one second of
+// jitter so that concurrent callers don't all retry in lockstep. A zero (or
+// already-elapsed, negative) wait gets only that jitter rather than being
+// padded up to some minimum floor.
+func sleepWithJitter(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	time.Sleep(d + time.Duration(mathrand.Int63n(int64(time.Second))))
+}
+
+func (g *githubissuesNotifier) SendNotification(ctx context.Context, build *cbpb.Build) error {
+	if !g.filter.Apply(ctx, build) {
+		return nil
+	}
+
+	if err := GetAndSetCommitterInfo(ctx, build, g, g.apiBaseURL); err != nil {
+		log.Printf("failed to get committer info for build %q: %v", build.GetId(), err)
+	}
+
+	view := &notifiers.TemplateView{
+		Build: &notifiers.BuildView{Build: build},
+	}
+	g.tmplView = view
+	iv := &issueView{TemplateView: view, CommitterLogin: build.GetSubstitutions()["GH_COMMITTER_LOGIN"]}
+
+	if g.dedupe {
+		return g.sendDeduped(ctx, g.apiBaseURL, build, iv)
+	}
+	return g.createIssue(ctx, g.apiBaseURL, iv, "")
+}
+
+// dedupeLabel is applied to every issue filed in dedupe mode so that it can
+// be found again with a labels-scoped search.
+const dedupeLabel = "cloud-build"
+
+// sendDeduped implements the `dedupe: true` delivery mode: failing builds
+// are matched against open issues by fingerprint and commented on instead
+// of always opening a new issue, and a SUCCESS build closes out any open
+// issue sharing its fingerprint.
+func (g *githubissuesNotifier) sendDeduped(ctx context.Context, apiBaseURL string, build *cbpb.Build, view *issueView) error {
+	fp := computeFingerprint(build)
+
+	issues, err := g.listOpenIssues(ctx, apiBaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to list open issues: %w", err)
+	}
+	match, found := findIssueByFingerprint(issues, fp)
+
+	if build.GetStatus() == cbpb.Build_SUCCESS {
+		if !found {
+			return nil
+		}
+		comment := fmt.Sprintf("Build succeeded: [view logs](%s)", build.GetLogUrl())
+		return g.closeIssue(ctx, apiBaseURL, match.Number, comment)
+	}
+
+	if found {
+		comment := fmt.Sprintf("Build failed again (status: %s): [view logs](%s)", build.GetStatus(), build.GetLogUrl())
+		return g.addComment(ctx, apiBaseURL, match.Number, comment)
+	}
+	return g.createIssue(ctx, apiBaseURL, view, fp)
+}
+
+// computeFingerprint derives a stable identity for a build's trigger/branch
+// so that repeated failures can be matched back to the same GitHub issue,
+// and so that a later SUCCESS build (which has no failing step of its own)
+// can still find and close it.
+func computeFingerprint(build *cbpb.Build) string {
+	parts := strings.Join([]string{
+		build.GetBuildTriggerId(),
+		build.GetSubstitutions()["BRANCH_NAME"],
+	}, "|")
+	sum := sha256.Sum256([]byte(parts))
+	return hex.EncodeToString(sum[:])
+}
+
+func fingerprintComment(fp string) string {
+	return fmt.Sprintf("<!-- cbn-fingerprint: %s -->", fp)
+}
+
+// listOpenIssues fetches every open, cloud-build-labeled issue in the repo,
+// following pagination to completion.
+func (g *githubissuesNotifier) listOpenIssues(ctx context.Context, apiBaseURL string) ([]*github.Issue, error) {
+	owner, name, err := splitRepo(g.githubRepo)
+	if err != nil {
+		return nil, err
+	}
+	client, err := g.ghClient(ctx, apiBaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &github.IssueListByRepoOptions{
+		State:       "open",
+		Labels:      []string{dedupeLabel},
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var all []*github.Issue
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, owner, name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list issues: %w", err)
+		}
+		all = append(all, issues...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+func findIssueByFingerprint(issues []*github.Issue, fp string) (*github.Issue, bool) {
+	needle := fingerprintComment(fp)
+	for _, issue := range issues {
+		if strings.Contains(issue.GetBody(), needle) {
+			return issue, true
+		}
+	}
+	return nil, false
+}
+
+func (g *githubissuesNotifier) addComment(ctx context.Context, apiBaseURL string, issueNumber int, comment string) error {
+	owner, name, err := splitRepo(g.githubRepo)
+	if err != nil {
+		return err
+	}
+	client, err := g.ghClient(ctx, apiBaseURL)
+	if err != nil {
+		return err
+	}
+	if _, _, err := client.Issues.CreateComment(ctx, owner, name, issueNumber, &github.IssueComment{Body: ptr(comment)}); err != nil {
+		return fmt.Errorf("failed to comment on issue #%d: %w", issueNumber, err)
+	}
+	return nil
+}
+
+func (g *githubissuesNotifier) closeIssue(ctx context.Context, apiBaseURL string, issueNumber int, comment string) error {
+	if err := g.addComment(ctx, apiBaseURL, issueNumber, comment); err != nil {
+		return err
+	}
+	owner, name, err := splitRepo(g.githubRepo)
+	if err != nil {
+		return err
+	}
+	client, err := g.ghClient(ctx, apiBaseURL)
+	if err != nil {
+		return err
+	}
+	if _, _, err := client.Issues.Edit(ctx, owner, name, issueNumber, &github.IssueRequest{State: ptr("closed")}); err != nil {
+		return fmt.Errorf("failed to close issue #%d: %w", issueNumber, err)
+	}
+	return nil
+}
+
+type issueRequest struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+func (g *githubissuesNotifier) createIssue(ctx context.Context, apiBaseURL string, view *issueView, fingerprint string) error {
+	buf := new(bytes.Buffer)
+	if err := g.tmpl.Execute(buf, view); err != nil {
+		return fmt.Errorf("failed to execute issue template: %w", err)
+	}
+
+	var payload issueRequest
+	if err := json.Unmarshal(buf.Bytes(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal rendered issue template: %w", err)
+	}
+
+	if fingerprint != "" {
+		payload.Body += "\n\n" + fingerprintComment(fingerprint)
+		payload.Labels = append(payload.Labels, dedupeLabel)
+	}
+
+	labels, err := renderTemplates(g.labelTemplates, view)
+	if err != nil {
+		return fmt.Errorf("failed to execute labels template: %w", err)
+	}
+	payload.Labels = append(payload.Labels, labels...)
+
+	assignees, err := renderTemplates(g.assigneeTemplates, view)
+	if err != nil {
+		return fmt.Errorf("failed to execute assignees template: %w", err)
+	}
+
+	owner, name, err := splitRepo(g.githubRepo)
+	if err != nil {
+		return err
+	}
+	client, err := g.ghClient(ctx, apiBaseURL)
+	if err != nil {
+		return err
+	}
+
+	req := &github.IssueRequest{
+		Title:  ptr(payload.Title),
+		Body:   ptr(payload.Body),
+		Labels: &payload.Labels,
+	}
+	if len(assignees) > 0 {
+		req.Assignees = &assignees
+	}
+	if g.milestoneTemplate != nil {
+		buf := new(bytes.Buffer)
+		if err := g.milestoneTemplate.Execute(buf, view); err != nil {
+			return fmt.Errorf("failed to execute milestone template: %w", err)
+		}
+		if title := buf.String(); title != "" {
+			num, err := g.resolveMilestoneNumber(ctx, owner, name, client, title)
+			if err != nil {
+				return err
+			}
+			req.Milestone = ptr(num)
+		}
+	}
+
+	if _, _, err := client.Issues.Create(ctx, owner, name, req); err != nil {
+		return fmt.Errorf("failed to create issue: %w", err)
+	}
+	return nil
+}
+
+// renderTemplates executes each template against view and returns the
+// rendered strings in order.
+func renderTemplates(tmpls []*template.Template, view *issueView) ([]string, error) {
+	out := make([]string, 0, len(tmpls))
+	for _, tmpl := range tmpls {
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, view); err != nil {
+			return nil, err
+		}
+		out = append(out, buf.String())
+	}
+	return out, nil
+}
+
+// resolveMilestoneNumber looks up the milestone number for a milestone
+// titled `title`, following pagination to completion.
+func (g *githubissuesNotifier) resolveMilestoneNumber(ctx context.Context, owner, name string, client *github.Client, title string) (int, error) {
+	opts := &github.MilestoneListOptions{State: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		milestones, resp, err := client.Issues.ListMilestones(ctx, owner, name, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list milestones: %w", err)
+		}
+		for _, m := range milestones {
+			if m.GetTitle() == title {
+				return m.GetNumber(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return 0, fmt.Errorf("no milestone found with title %q", title)
+}
+
+// ptr returns a pointer to v, for the handful of go-github request fields
+// that are optional pointers.
+func ptr[T any](v T) *T {
+	return &v
+}
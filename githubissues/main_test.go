@@ -17,14 +17,25 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"text/template"
+	"time"
 
 	cbpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
 	"github.com/GoogleCloudPlatform/cloud-build-notifiers/lib/notifiers"
+	"github.com/google/go-github/v63/github"
 )
 
 const githubToken = "ghtABC="
@@ -145,6 +156,117 @@ func TestConfigs(t *testing.T) {
 			},
 		},
 		wantErr: true,
+	}, {
+		name: "dedupe enabled",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken": map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":  repo,
+						"dedupe":      true,
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+	}, {
+		name: "dedupe not a bool",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken": map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":  repo,
+						"dedupe":      "yes",
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+		wantErr: true,
+	}, {
+		name: "valid githubApiUrl",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken":  map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":   repo,
+						"githubApiUrl": "https://github.example.com/api/v3/",
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+	}, {
+		name: "malformed githubApiUrl",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken":  map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":   repo,
+						"githubApiUrl": "://not a url",
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+		wantErr: true,
+	}, {
+		name: "non-https githubApiUrl",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken":  map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":   repo,
+						"githubApiUrl": "http://github.example.com",
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+		wantErr: true,
+	}, {
+		name: "valid labels and assignees",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken": map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":  repo,
+						"labels":      []interface{}{"trigger-{{.Build.BuildTriggerId}}"},
+						"assignees":   []interface{}{"{{.CommitterLogin}}"},
+						"milestone":   "{{.Build.ProjectId}}",
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+	}, {
+		name: "unknown template variable in labels",
+		cfg: &notifiers.Config{
+			Spec: &notifiers.Spec{
+				Notification: &notifiers.Notification{
+					Filter: `build.status == Build.Status.SUCCESS`,
+					Delivery: map[string]interface{}{
+						"githubToken": map[interface{}]interface{}{"secretRef": "mytoken"},
+						"githubRepo":  repo,
+						"labels":      []interface{}{"{{.NotAField}}"},
+					},
+				},
+				Secrets: goodSecret,
+			},
+		},
+		wantErr: true,
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
 			n := new(githubissuesNotifier)
@@ -164,6 +286,106 @@ func TestConfigs(t *testing.T) {
 	}
 }
 
+type staticSecretGetter struct {
+	value string
+}
+
+func (s *staticSecretGetter) GetSecret(_ context.Context, _ string) (string, error) {
+	return s.value, nil
+}
+
+func TestSetUpGithubApp(t *testing.T) {
+	_, pemStr := mustGenerateTestKey(t)
+	const repo = "somename/somerepo"
+	goodSecret := []*notifiers.Secret{{LocalName: "appkey", ResourceName: "mysekrit"}}
+	sg := &staticSecretGetter{value: pemStr}
+
+	for _, tc := range []struct {
+		name    string
+		app     map[string]interface{}
+		wantErr bool
+	}{{
+		name: "valid githubApp config",
+		app: map[string]interface{}{
+			"appId":          "123",
+			"installationId": float64(456),
+			"secretRef":      "appkey",
+		},
+	}, {
+		name: "missing appId",
+		app: map[string]interface{}{
+			"installationId": float64(456),
+			"secretRef":      "appkey",
+		},
+		wantErr: true,
+	}, {
+		name: "non-numeric appId",
+		app: map[string]interface{}{
+			"appId":          "not-a-number",
+			"installationId": float64(456),
+			"secretRef":      "appkey",
+		},
+		wantErr: true,
+	}, {
+		name: "missing installationId",
+		app: map[string]interface{}{
+			"appId":     "123",
+			"secretRef": "appkey",
+		},
+		wantErr: true,
+	}, {
+		name: "non-numeric installationId",
+		app: map[string]interface{}{
+			"appId":          "123",
+			"installationId": "not-a-number",
+			"secretRef":      "appkey",
+		},
+		wantErr: true,
+	}, {
+		name: "missing secretRef",
+		app: map[string]interface{}{
+			"appId":          "123",
+			"installationId": float64(456),
+		},
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &notifiers.Config{
+				Spec: &notifiers.Spec{
+					Notification: &notifiers.Notification{
+						Filter: `build.status == Build.Status.SUCCESS`,
+						Delivery: map[string]interface{}{
+							"githubRepo": repo,
+							"githubApp":  tc.app,
+						},
+					},
+					Secrets: goodSecret,
+				},
+			}
+			n := new(githubissuesNotifier)
+			err := n.SetUp(context.Background(), cfg, "", sg, nil)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("SetUp got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetUp got unexpected error: %v", err)
+			}
+			if n.appID != 123 {
+				t.Errorf("got appID %d, want 123", n.appID)
+			}
+			if n.installationID != 456 {
+				t.Errorf("got installationID %d, want 456", n.installationID)
+			}
+			if n.appPrivateKey == nil {
+				t.Error("appPrivateKey not set")
+			}
+		})
+	}
+}
+
 func TestGetGithubRepo(t *testing.T) {
 	for _, tc := range []struct {
 		name     string
@@ -196,8 +418,8 @@ func TestGetGithubRepo(t *testing.T) {
 
 func TestGetAndSetCommitterInfo(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.HasPrefix(r.URL.Path, "/kramphub/repo/") {
-			t.Errorf("Expected to request '/kramphub/repo/*', got: %s", r.URL.Path)
+		if !strings.HasPrefix(r.URL.Path, "/repos/kramphub/repo/") {
+			t.Errorf("Expected to request '/repos/kramphub/repo/*', got: %s", r.URL.Path)
 		}
 		w.WriteHeader(http.StatusOK)
 		if strings.Contains(r.URL.Path, "commits") {
@@ -239,3 +461,465 @@ func TestGetAndSetCommitterInfo(t *testing.T) {
 		t.Errorf("Failed to get tagger login")
 	}
 }
+
+func mustGenerateTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test RSA key: %v", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	return key, string(pem.EncodeToMemory(block))
+}
+
+func TestMintInstallationToken(t *testing.T) {
+	key, _ := mustGenerateTestKey(t)
+	wantExpiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/installations/42/access_tokens" {
+			t.Errorf("expected request to '/app/installations/42/access_tokens', got: %s", r.URL.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			t.Errorf("expected a Bearer JWT, got: %q", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{
+			"token":      "v1.installation-token",
+			"expires_at": wantExpiry.Format(time.RFC3339),
+		})
+	}))
+	defer server.Close()
+
+	g := &githubissuesNotifier{
+		appID:          1,
+		installationID: 42,
+		appPrivateKey:  key,
+	}
+	token, exp, err := g.mintInstallationToken(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("mintInstallationToken got unexpected error: %v", err)
+	}
+	if token != "v1.installation-token" {
+		t.Errorf("got token %q, want %q", token, "v1.installation-token")
+	}
+	if !exp.Equal(wantExpiry) {
+		t.Errorf("got expiry %v, want %v", exp, wantExpiry)
+	}
+}
+
+func TestGetTokenCaching(t *testing.T) {
+	if _, err := (&githubissuesNotifier{}).getToken(context.Background()); err == nil {
+		t.Error("getToken() with no auth configured got no error, want one")
+	}
+
+	key, _ := mustGenerateTestKey(t)
+	var mintCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&mintCalls, 1)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{
+			"token":      "refreshed-token",
+			"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
+	}))
+	defer server.Close()
+
+	g := &githubissuesNotifier{
+		appID:             1,
+		installationID:    42,
+		appPrivateKey:     key,
+		apiBaseURL:        server.URL,
+		installationToken: "cached-token",
+		installationExp:   time.Now().Add(5 * time.Minute),
+	}
+	if token, err := g.getToken(context.Background()); err != nil {
+		t.Fatalf("getToken got unexpected error: %v", err)
+	} else if token != "cached-token" {
+		t.Errorf("got token %q, want the cached token reused without minting a new one", token)
+	}
+	if got := atomic.LoadInt32(&mintCalls); got != 0 {
+		t.Errorf("got %d mint calls for a token well within its expiry, want 0", got)
+	}
+
+	// Within tokenRefreshSkew of expiry, the cached token must be treated
+	// as stale and a fresh one minted instead of reused.
+	g.installationExp = time.Now().Add(tokenRefreshSkew / 2)
+	token, err := g.getToken(context.Background())
+	if err != nil {
+		t.Fatalf("getToken got unexpected error: %v", err)
+	}
+	if token != "refreshed-token" {
+		t.Errorf("got token %q, want the freshly minted token", token)
+	}
+	if got := atomic.LoadInt32(&mintCalls); got != 1 {
+		t.Errorf("got %d mint calls for a token within the refresh skew, want 1", got)
+	}
+}
+
+func TestSleepWithJitterHonorsZeroWait(t *testing.T) {
+	start := time.Now()
+	sleepWithJitter(0)
+	if elapsed := time.Since(start); elapsed >= baseRetryBackoff {
+		t.Errorf("sleepWithJitter(0) took %v, want well under the %v backoff floor it used to be padded up to", elapsed, baseRetryBackoff)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		failResponse   func(w http.ResponseWriter)
+		wantFinalCalls int
+	}{{
+		name: "secondary rate limit honors Retry-After",
+		failResponse: func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		wantFinalCalls: 2,
+	}, {
+		name: "primary rate limit honors x-ratelimit-reset",
+		failResponse: func(w http.ResponseWriter) {
+			w.Header().Set("x-ratelimit-remaining", "0")
+			w.Header().Set("x-ratelimit-reset", strconv.FormatInt(time.Now().Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+		},
+		wantFinalCalls: 2,
+	}, {
+		name: "transient 5xx is retried",
+		failResponse: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		wantFinalCalls: 2,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					tc.failResponse(w)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			rt := newRetryTransport(http.DefaultTransport)
+			rt.sleep = func(time.Duration) {}
+			client := &http.Client{Transport: rt}
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("client.Get got unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("got final status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if got := int(atomic.LoadInt32(&calls)); got != tc.wantFinalCalls {
+				t.Errorf("got %d requests, want %d", got, tc.wantFinalCalls)
+			}
+		})
+	}
+}
+
+func TestRetryTransportExhaustsRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "0")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message": "You have exceeded a secondary rate limit.", "documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`))
+	}))
+	defer server.Close()
+
+	rt := newRetryTransport(http.DefaultTransport)
+	rt.sleep = func(time.Duration) {}
+	client := github.NewClient(&http.Client{Transport: rt})
+	u, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("url.Parse failed: %v", err)
+	}
+	client.BaseURL = u
+
+	_, _, err = client.Issues.Get(context.Background(), "kramphub", "repo", 1)
+	if err == nil {
+		t.Fatal("Issues.Get got no error, want a rate limit error")
+	}
+	var rlErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if !errors.As(err, &rlErr) && !errors.As(err, &abuseErr) {
+		t.Errorf("got error %v (%T), want a *github.RateLimitError or *github.AbuseRateLimitError", err, err)
+	}
+	if got, want := int(atomic.LoadInt32(&calls)), maxRetries+1; got != want {
+		t.Errorf("got %d requests, want %d", got, want)
+	}
+}
+
+func TestCreateIssue(t *testing.T) {
+	const repo = "kramphub/repo"
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/"+repo+"/issues" {
+			t.Errorf("expected request to '/repos/%s/issues', got: %s", repo, r.URL.Path)
+		}
+		gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tmpl, err := template.New("issue_template").Parse(issuePayload)
+	if err != nil {
+		t.Fatalf("template.Parse failed: %v", err)
+	}
+	g := &githubissuesNotifier{
+		githubRepo:  repo,
+		githubToken: githubToken,
+		tmpl:        tmpl,
+	}
+	view := &issueView{TemplateView: &notifiers.TemplateView{
+		Build: &notifiers.BuildView{Build: &cbpb.Build{ProjectId: "my-project-id", Status: cbpb.Build_SUCCESS}},
+	}}
+	if err := g.createIssue(context.Background(), server.URL, view, ""); err != nil {
+		t.Fatalf("createIssue got unexpected error: %v", err)
+	}
+	if gotAuth != "Bearer "+githubToken {
+		t.Errorf("got Authorization header %q, want %q", gotAuth, "Bearer "+githubToken)
+	}
+}
+
+func TestCreateIssueWithLabelsAssigneesMilestone(t *testing.T) {
+	const repo = "kramphub/repo"
+	var gotReq github.IssueRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/milestones"):
+			json.NewEncoder(w).Encode([]*github.Milestone{
+				{Number: ptr(3), Title: ptr("v1.0")},
+			})
+		case strings.HasSuffix(r.URL.Path, "/issues"):
+			if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+				t.Fatalf("failed to decode issue request: %v", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	tmpl, err := template.New("issue_template").Parse(issuePayload)
+	if err != nil {
+		t.Fatalf("template.Parse failed: %v", err)
+	}
+	labelTmpl, err := template.New("labels[0]").Parse("trigger-{{.Build.BuildTriggerId}}")
+	if err != nil {
+		t.Fatalf("template.Parse failed: %v", err)
+	}
+	assigneeTmpl, err := template.New("assignees[0]").Parse("{{.CommitterLogin}}")
+	if err != nil {
+		t.Fatalf("template.Parse failed: %v", err)
+	}
+	milestoneTmpl, err := template.New("milestone").Parse("v1.0")
+	if err != nil {
+		t.Fatalf("template.Parse failed: %v", err)
+	}
+	g := &githubissuesNotifier{
+		githubRepo:        repo,
+		githubToken:       githubToken,
+		tmpl:              tmpl,
+		labelTemplates:    []*template.Template{labelTmpl},
+		assigneeTemplates: []*template.Template{assigneeTmpl},
+		milestoneTemplate: milestoneTmpl,
+	}
+	view := &issueView{
+		TemplateView: &notifiers.TemplateView{
+			Build: &notifiers.BuildView{Build: &cbpb.Build{ProjectId: "my-project-id", BuildTriggerId: "trigger-1", Status: cbpb.Build_SUCCESS}},
+		},
+		CommitterLogin: "octocat",
+	}
+	if err := g.createIssue(context.Background(), server.URL, view, ""); err != nil {
+		t.Fatalf("createIssue got unexpected error: %v", err)
+	}
+
+	if got, want := gotReq.GetLabels(), []string{"trigger-trigger-1"}; len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+	if got, want := gotReq.Assignees, []string{"octocat"}; len(*got) != len(want) || (*got)[0] != want[0] {
+		t.Errorf("got assignees %v, want %v", *got, want)
+	}
+	if gotReq.GetMilestone() != 3 {
+		t.Errorf("got milestone %d, want 3", gotReq.GetMilestone())
+	}
+}
+
+func TestSendDeduped(t *testing.T) {
+	const repo = "kramphub/repo"
+	build := &cbpb.Build{
+		ProjectId:      "my-project-id",
+		BuildTriggerId: "trigger-1",
+		Status:         cbpb.Build_FAILURE,
+		LogUrl:         "https://some.example.com/log/url",
+		Substitutions:  map[string]string{"BRANCH_NAME": "main"},
+		Steps: []*cbpb.BuildStep{
+			{Id: "step-1", Status: cbpb.Build_FAILURE},
+		},
+	}
+	fp := computeFingerprint(build)
+
+	for _, tc := range []struct {
+		name        string
+		buildStatus cbpb.Build_Status
+		steps       []*cbpb.BuildStep
+		existing    []*github.Issue
+		wantMethod  string
+		wantPathHas string
+		wantNoCall  bool
+	}{{
+		name:        "no existing issue creates one",
+		buildStatus: cbpb.Build_FAILURE,
+		existing:    nil,
+		wantMethod:  http.MethodPost,
+		wantPathHas: "/repos/" + repo + "/issues",
+	}, {
+		name:        "existing issue gets a comment",
+		buildStatus: cbpb.Build_FAILURE,
+		existing:    []*github.Issue{{Number: ptr(7), Body: ptr("boom\n\n" + fingerprintComment(fp))}},
+		wantMethod:  http.MethodPost,
+		wantPathHas: "/repos/" + repo + "/issues/7/comments",
+	}, {
+		name:        "success with no existing issue is a no-op",
+		buildStatus: cbpb.Build_SUCCESS,
+		existing:    nil,
+		wantNoCall:  true,
+	}, {
+		name:        "success closes the existing issue",
+		buildStatus: cbpb.Build_SUCCESS,
+		existing:    []*github.Issue{{Number: ptr(7), Body: ptr("boom\n\n" + fingerprintComment(fp))}},
+		wantMethod:  http.MethodPatch,
+		wantPathHas: "/repos/" + repo + "/issues/7",
+	}, {
+		name:        "success with no failing step still closes the existing issue",
+		buildStatus: cbpb.Build_SUCCESS,
+		// A real Cloud Build SUCCESS event has no Build_FAILURE step at
+		// all, unlike the synthetic builds above that reuse the FAILURE
+		// build's Steps. The fingerprint must match on trigger/branch
+		// alone, not on whichever step happened to fail originally.
+		steps:       []*cbpb.BuildStep{{Id: "step-1", Status: cbpb.Build_SUCCESS}},
+		existing:    []*github.Issue{{Number: ptr(7), Body: ptr("boom\n\n" + fingerprintComment(fp))}},
+		wantMethod:  http.MethodPatch,
+		wantPathHas: "/repos/" + repo + "/issues/7",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotMethod, gotPath string
+			calledMutation := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(tc.existing)
+					return
+				}
+				calledMutation = true
+				gotMethod = r.Method
+				gotPath = r.URL.Path
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("{}"))
+			}))
+			defer server.Close()
+
+			tmpl, err := template.New("issue_template").Parse(issuePayload)
+			if err != nil {
+				t.Fatalf("template.Parse failed: %v", err)
+			}
+			g := &githubissuesNotifier{
+				githubRepo:  repo,
+				githubToken: githubToken,
+				tmpl:        tmpl,
+				dedupe:      true,
+			}
+			steps := tc.steps
+			if steps == nil {
+				steps = build.Steps
+			}
+			b := &cbpb.Build{
+				ProjectId:      build.ProjectId,
+				BuildTriggerId: build.BuildTriggerId,
+				Status:         tc.buildStatus,
+				LogUrl:         build.LogUrl,
+				Substitutions:  build.Substitutions,
+				Steps:          steps,
+			}
+			view := &issueView{TemplateView: &notifiers.TemplateView{Build: &notifiers.BuildView{Build: b}}}
+
+			if err := g.sendDeduped(context.Background(), server.URL, b, view); err != nil {
+				t.Fatalf("sendDeduped got unexpected error: %v", err)
+			}
+
+			if tc.wantNoCall {
+				if calledMutation {
+					t.Errorf("expected no mutating call, got %s %s", gotMethod, gotPath)
+				}
+				return
+			}
+			if !calledMutation {
+				t.Fatalf("expected a mutating call, got none")
+			}
+			if gotMethod != tc.wantMethod {
+				t.Errorf("got method %q, want %q", gotMethod, tc.wantMethod)
+			}
+			if gotPath != tc.wantPathHas {
+				t.Errorf("got path %q, want %q", gotPath, tc.wantPathHas)
+			}
+		})
+	}
+}
+
+func TestValidateAPIBaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		in      interface{}
+		want    string
+		wantErr bool
+	}{{
+		name: "unset defaults to api.github.com",
+		in:   nil,
+		want: "https://api.github.com",
+	}, {
+		name: "trailing slash is trimmed",
+		in:   "https://github.example.com/api/v3/",
+		want: "https://github.example.com/api/v3",
+	}, {
+		name:    "malformed URL",
+		in:      "://not a url",
+		wantErr: true,
+	}, {
+		name:    "non-https rejected",
+		in:      "http://github.example.com",
+		wantErr: true,
+	}, {
+		name:    "non-string rejected",
+		in:      123,
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := validateAPIBaseURL(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("validateAPIBaseURL(%v) got no error, want one", tc.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateAPIBaseURL(%v) got unexpected error: %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Errorf("validateAPIBaseURL(%v) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}